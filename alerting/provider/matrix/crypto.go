@@ -0,0 +1,215 @@
+package matrix
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/TwiN/gatus/v4/client"
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/crypto"
+	"maunium.net/go/mautrix/crypto/sql_store_upgrade"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+// Encryption is the configuration necessary to deliver alerts into rooms that require end-to-end
+// encryption
+type Encryption struct {
+	// UserID is the bot's full Matrix user ID (e.g. @gatus:matrix.org), needed to bootstrap the
+	// crypto machine and share Megolm sessions with the rest of the room
+	UserID string `yaml:"user-id"`
+	// DeviceID is the device ID the bot's Olm/Megolm sessions are authenticated under
+	DeviceID string `yaml:"device-id"`
+	// PickleKey encrypts the on-disk crypto store; treat it like a secret
+	PickleKey string `yaml:"pickle-key"`
+	// StorePath is the path to the SQLite database the Olm/Megolm session store is persisted in
+	StorePath string `yaml:"store-path"`
+}
+
+// IsValid returns whether the encryption configuration has everything it needs to start a crypto machine
+func (encryption *Encryption) IsValid() bool {
+	return encryption != nil && len(encryption.UserID) > 0 && len(encryption.DeviceID) > 0 && len(encryption.PickleKey) > 0 && len(encryption.StorePath) > 0
+}
+
+// cryptoMachine wraps the mautrix OlmMachine used to encrypt outgoing events, backed by a
+// persistent store so that device and session state survives restarts
+type cryptoMachine struct {
+	olm    *crypto.OlmMachine
+	client *mautrix.Client
+}
+
+// encryptIfRoomIsEncrypted checks whether roomID has encryption enabled and, if so, shares the
+// bot's outbound Megolm session with every member currently joined to the room (so that their
+// devices can actually decrypt what follows) and encrypts body, returning its JSON-encoded
+// m.room.encrypted content. It returns an empty string if the room isn't encrypted, in which case
+// the caller should send body as-is.
+func (provider *AlertProvider) encryptIfRoomIsEncrypted(baseURL, accessToken, roomID, body string) (string, error) {
+	if !provider.Encryption.IsValid() {
+		return "", nil
+	}
+	encrypted, err := provider.isRoomEncrypted(baseURL, accessToken, roomID)
+	if err != nil {
+		return "", err
+	}
+	if !encrypted {
+		return "", nil
+	}
+	machine, err := provider.getOrCreateCryptoMachine(baseURL, accessToken)
+	if err != nil {
+		return "", err
+	}
+	if err := provider.shareGroupSession(machine, id.RoomID(roomID)); err != nil {
+		return "", fmt.Errorf("failed to share room key with room members: %w", err)
+	}
+	var content map[string]any
+	if err := json.Unmarshal([]byte(body), &content); err != nil {
+		return "", err
+	}
+	encryptedContent, err := machine.olm.EncryptMegolmEvent(id.RoomID(roomID), "m.room.message", content)
+	if err != nil {
+		return "", err
+	}
+	encryptedBody, err := json.Marshal(encryptedContent)
+	if err != nil {
+		return "", err
+	}
+	return string(encryptedBody), nil
+}
+
+// shareGroupSession shares the bot's outbound Megolm session for roomID with every member
+// currently joined to it. ShareGroupSession only actually sends to-device messages to devices
+// that don't already have the current session, so calling this before every send is cheap once
+// the room's membership has settled and is what makes messages decryptable by the rest of the
+// room at all (without it, EncryptMegolmEvent still "succeeds" but nobody else can read it).
+func (provider *AlertProvider) shareGroupSession(machine *cryptoMachine, roomID id.RoomID) error {
+	ctx := context.Background()
+	joined, err := machine.client.JoinedMembers(ctx, roomID)
+	if err != nil {
+		return fmt.Errorf("failed to list joined members of %s: %w", roomID, err)
+	}
+	members := make([]id.UserID, 0, len(joined.Joined))
+	for userID := range joined.Joined {
+		members = append(members, userID)
+	}
+	return machine.olm.ShareGroupSession(ctx, roomID, members)
+}
+
+// isRoomEncrypted checks the m.room.encryption state event of roomID to determine whether events
+// sent to it must be encrypted
+func (provider *AlertProvider) isRoomEncrypted(baseURL, accessToken, roomID string) (bool, error) {
+	request, err := http.NewRequest(
+		http.MethodGet,
+		fmt.Sprintf("%s/_matrix/client/v3/rooms/%s/state/m.room.encryption", baseURL, url.PathEscape(roomID)),
+		nil,
+	)
+	if err != nil {
+		return false, err
+	}
+	request.Header.Set("Authorization", "Bearer "+accessToken)
+	response, err := client.GetHTTPClient(nil).Do(request)
+	if err != nil {
+		return false, err
+	}
+	defer response.Body.Close()
+	// A 404 means the room has no m.room.encryption state event, i.e. it isn't encrypted
+	if response.StatusCode == http.StatusNotFound {
+		return false, nil
+	}
+	return response.StatusCode == http.StatusOK, nil
+}
+
+// getOrCreateCryptoMachine lazily opens the persistent crypto store at Encryption.StorePath and
+// starts an OlmMachine for the provider's bot user/device, performing initial device key upload
+// on first run. baseURL and accessToken are used to log in a real mautrix.Client: the OlmMachine
+// needs one to query room members/devices, claim one-time keys and send the to-device events
+// required to establish sessions with rooms it hasn't already shared keys in.
+func (provider *AlertProvider) getOrCreateCryptoMachine(baseURL, accessToken string) (*cryptoMachine, error) {
+	provider.olmMutex.Lock()
+	defer provider.olmMutex.Unlock()
+	if provider.olmMachine != nil {
+		return provider.olmMachine, nil
+	}
+	mautrixClient, err := mautrix.NewClient(baseURL, id.UserID(provider.Encryption.UserID), accessToken)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Matrix client for crypto machine: %w", err)
+	}
+	mautrixClient.DeviceID = id.DeviceID(provider.Encryption.DeviceID)
+	store, err := crypto.NewSQLCryptoStore(
+		"sqlite3",
+		provider.Encryption.StorePath,
+		string(id.UserID(provider.Encryption.UserID)),
+		id.DeviceID(provider.Encryption.DeviceID),
+		[]byte(provider.Encryption.PickleKey),
+		&sql_store_upgrade.NoopLogger{},
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open crypto store at %s: %w", provider.Encryption.StorePath, err)
+	}
+	if err := store.CreateTables(); err != nil {
+		return nil, fmt.Errorf("failed to initialize crypto store: %w", err)
+	}
+	olm := crypto.NewOlmMachine(mautrixClient, nil, store, &onDemandStateStore{client: mautrixClient})
+	if err := olm.Load(); err != nil {
+		return nil, fmt.Errorf("failed to load crypto machine: %w", err)
+	}
+	// Uploads the device's identity keys if they haven't been shared yet, along with one-time keys
+	if err := olm.ShareKeys(context.Background(), -1); err != nil {
+		return nil, fmt.Errorf("failed to upload device keys: %w", err)
+	}
+	provider.olmMachine = &cryptoMachine{olm: olm, client: mautrixClient}
+	return provider.olmMachine, nil
+}
+
+// onDemandStateStore implements the minimal crypto.StateStore the OlmMachine needs (whether a
+// room is encrypted, and its encryption settings) by querying room state directly instead of
+// from a cache populated by a long-running /sync loop, since this provider only calls the
+// homeserver when it actually has an alert to send.
+type onDemandStateStore struct {
+	client *mautrix.Client
+}
+
+// IsEncrypted returns whether roomID has an m.room.encryption state event
+func (store *onDemandStateStore) IsEncrypted(roomID id.RoomID) bool {
+	return store.GetEncryptionEvent(roomID) != nil
+}
+
+// GetEncryptionEvent returns roomID's m.room.encryption state event content, or nil if it has none
+func (store *onDemandStateStore) GetEncryptionEvent(roomID id.RoomID) *event.EncryptionEventContent {
+	var content event.EncryptionEventContent
+	if err := store.client.StateEvent(context.Background(), roomID, event.StateEncryption, "", &content); err != nil {
+		return nil
+	}
+	return &content
+}
+
+// FindSharedRooms isn't backed by anything here: without a /sync loop this provider has no
+// membership cache to consult, and ShareGroupSession is always called with an explicit roomID and
+// member list, so this is never actually needed for a room key to be shared correctly.
+func (store *onDemandStateStore) FindSharedRooms(userID id.UserID) []id.RoomID {
+	return nil
+}
+
+// PrepareDeviceForVerification starts the crypto machine for encryption and uploads this device's
+// identity keys to the homeserver, which is the prerequisite for an operator to verify it from
+// their own Matrix client.
+//
+// It does NOT perform interactive SAS verification or cross-signing bootstrap: both require a
+// live, interactive exchange with a counterpart client (emoji/number comparison, or accepting a
+// cross-signing request), which has no place in a one-shot call like this one. Once this device's
+// keys are on the homeserver, complete the actual verification from the operator's own client by
+// looking up this bot's user/device ID and confirming the session fingerprint there. This is
+// intentionally a free function rather than a provider method since it's a one-time operator
+// action, meant to be wired into a `gatus verify-matrix-device` CLI subcommand rather than run on
+// every alert.
+func PrepareDeviceForVerification(homeserverURL, accessToken string, encryption *Encryption) error {
+	if !encryption.IsValid() {
+		return fmt.Errorf("encryption configuration is incomplete")
+	}
+	provider := &AlertProvider{Encryption: encryption}
+	baseURL := provider.resolveHomeserverURL(homeserverURL)
+	_, err := provider.getOrCreateCryptoMachine(baseURL, accessToken)
+	return err
+}
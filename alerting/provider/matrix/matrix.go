@@ -2,12 +2,16 @@ package matrix
 
 import (
 	"bytes"
+	cryptorand "crypto/rand"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"io"
-	"math/rand"
 	"net/http"
 	"net/url"
-	"time"
+	"strings"
+	"sync"
+	"sync/atomic"
 
 	"github.com/TwiN/gatus/v4/alerting/alert"
 	"github.com/TwiN/gatus/v4/client"
@@ -28,6 +32,24 @@ type AlertProvider struct {
 
 	// Overrides is a list of Override that may be prioritized over the default configuration
 	Overrides []Override `yaml:"overrides,omitempty"`
+
+	// Encryption configures end-to-end encryption support, required to deliver alerts into rooms
+	// that have encryption enabled (optional)
+	Encryption *Encryption `yaml:"encryption,omitempty"`
+
+	// wellKnownBaseURLs caches the result of resolveHomeserverURL's well-known lookup, keyed by
+	// the configured server name, so that it's only performed once per provider
+	wellKnownBaseURLs map[string]string
+	wellKnownMutex    sync.Mutex
+
+	// olmMachine is the lazily-initialized crypto machine used to encrypt events for Encryption
+	olmMachine *cryptoMachine
+	olmMutex   sync.Mutex
+
+	// threadRoots tracks, per endpoint/alert, the event_id that subsequent related messages
+	// should be threaded under
+	threadRoots map[string]threadRoot
+	threadMutex sync.Mutex
 }
 
 // Override is a case under which the default integration is overridden
@@ -49,6 +71,9 @@ type matrixProviderConfig struct {
 
 // IsValid returns whether the provider's configuration is valid
 func (provider *AlertProvider) IsValid() bool {
+	if provider.Encryption != nil && !provider.Encryption.IsValid() {
+		return false
+	}
 	registeredGroups := make(map[string]bool)
 	if provider.Overrides != nil {
 		for _, override := range provider.Overrides {
@@ -63,48 +88,184 @@ func (provider *AlertProvider) IsValid() bool {
 
 // Send an alert using the provider
 func (provider *AlertProvider) Send(endpoint *core.Endpoint, alert *alert.Alert, result *core.Result, resolved bool) error {
-	buffer := bytes.NewBuffer([]byte(provider.buildRequestBody(endpoint, alert, result, resolved)))
 	config := provider.getConfigForGroup(endpoint.Group)
-	if config.HomeserverURL == "" {
-		config.HomeserverURL = defaultHomeserverURL
+	threadKey := provider.threadKey(endpoint, alert)
+	threadRootEventID := provider.getThreadRoot(threadKey)
+	body, err := provider.buildRequestBody(endpoint, alert, result, resolved, threadRootEventID)
+	if err != nil {
+		return err
 	}
-	txnId := randStringBytes(24)
+	eventID, err := provider.SendRaw(config.HomeserverURL, config.AccessToken, config.InternalRoomID, body)
+	if err != nil {
+		return err
+	}
+	if resolved {
+		provider.clearThreadRoot(threadKey)
+	} else if threadRootEventID == "" {
+		provider.setThreadRoot(threadKey, eventID)
+	}
+	return nil
+}
+
+// SendRaw sends a pre-built m.room.message event body to the given room on the given homeserver,
+// returning the event_id Matrix assigned it. It's the low-level primitive shared by Send and
+// other callers (e.g. the Alertmanager receiver) that need to deliver a message to Matrix without
+// going through the endpoint-alert rendering path.
+func (provider *AlertProvider) SendRaw(homeserverURL, accessToken, roomID, body string) (string, error) {
+	baseURL := provider.resolveHomeserverURL(homeserverURL)
+	eventType := "m.room.message"
+	if provider.Encryption != nil {
+		encryptedBody, err := provider.encryptIfRoomIsEncrypted(baseURL, accessToken, roomID, body)
+		if err != nil {
+			return "", fmt.Errorf("failed to encrypt event for room %s: %w", roomID, err)
+		}
+		if encryptedBody != "" {
+			body, eventType = encryptedBody, "m.room.encrypted"
+		}
+	}
+	txnId := nextTxnID()
+	response, err := provider.put(baseURL, "v3", accessToken, roomID, eventType, txnId, body)
+	if err != nil {
+		return "", err
+	}
+	if response.StatusCode == http.StatusNotFound {
+		// The v3 client-server endpoints are only available on Matrix 1.1+ homeservers; fall back
+		// to the deprecated r0 path for older ones.
+		response.Body.Close()
+		response, err = provider.put(baseURL, "r0", accessToken, roomID, eventType, txnId, body)
+		if err != nil {
+			return "", err
+		}
+	}
+	defer response.Body.Close()
+	respBody, _ := io.ReadAll(response.Body)
+	if response.StatusCode > 399 {
+		return "", fmt.Errorf("call to provider alert returned status code %d: %s", response.StatusCode, string(respBody))
+	}
+	var sendResponse struct {
+		EventID string `json:"event_id"`
+	}
+	_ = json.Unmarshal(respBody, &sendResponse)
+	return sendResponse.EventID, nil
+}
+
+// put sends the given event type to the given API version's send endpoint, authenticating via
+// the Authorization header rather than the deprecated access_token query parameter
+func (provider *AlertProvider) put(baseURL, apiVersion, accessToken, roomID, eventType, txnId, body string) (*http.Response, error) {
 	request, err := http.NewRequest(
 		http.MethodPut,
-		fmt.Sprintf("%s/_matrix/client/r0/rooms/%s/send/m.room.message/%s?access_token=%s",
-			config.HomeserverURL,
-			url.PathEscape(config.InternalRoomID),
+		fmt.Sprintf("%s/_matrix/client/%s/rooms/%s/send/%s/%s",
+			baseURL,
+			apiVersion,
+			url.PathEscape(roomID),
+			eventType,
 			txnId,
-			url.QueryEscape(config.AccessToken),
 		),
-		buffer,
+		bytes.NewBufferString(body),
 	)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	request.Header.Set("Content-Type", "application/json")
-	response, err := client.GetHTTPClient(nil).Do(request)
+	request.Header.Set("Authorization", "Bearer "+accessToken)
+	return client.GetHTTPClient(nil).Do(request)
+}
+
+// resolveHomeserverURL returns the base URL to send requests to for the given configured
+// homeserver. If homeserverURL is already a full URL, it's returned as-is; if it's a bare server
+// name (e.g. "matrix.org"), its .well-known/matrix/client document is looked up to discover the
+// actual m.homeserver.base_url, and the result is cached for the lifetime of the provider.
+func (provider *AlertProvider) resolveHomeserverURL(homeserverURL string) string {
+	if homeserverURL == "" {
+		return defaultHomeserverURL
+	}
+	if strings.HasPrefix(homeserverURL, "http://") || strings.HasPrefix(homeserverURL, "https://") {
+		return homeserverURL
+	}
+	provider.wellKnownMutex.Lock()
+	defer provider.wellKnownMutex.Unlock()
+	if provider.wellKnownBaseURLs == nil {
+		provider.wellKnownBaseURLs = make(map[string]string)
+	}
+	if baseURL, ok := provider.wellKnownBaseURLs[homeserverURL]; ok {
+		return baseURL
+	}
+	baseURL := lookupWellKnownBaseURL(homeserverURL)
+	provider.wellKnownBaseURLs[homeserverURL] = baseURL
+	return baseURL
+}
+
+// lookupWellKnownBaseURL performs the well-known client discovery described in the Matrix spec,
+// falling back to https://<serverName> if the document is missing or malformed
+func lookupWellKnownBaseURL(serverName string) string {
+	fallback := "https://" + serverName
+	response, err := client.GetHTTPClient(nil).Get(fallback + "/.well-known/matrix/client")
 	if err != nil {
-		return err
+		return fallback
 	}
-	if response.StatusCode > 399 {
-		body, _ := io.ReadAll(response.Body)
-		return fmt.Errorf("call to provider alert returned status code %d: %s", response.StatusCode, string(body))
-	}
-	return err
-}
-
-// buildRequestBody builds the request body for the provider
-func (provider *AlertProvider) buildRequestBody(endpoint *core.Endpoint, alert *alert.Alert, result *core.Result, resolved bool) string {
-	return fmt.Sprintf(`{
-	"msgtype": "m.text",
-	"format": "org.matrix.custom.html",
-	"body": "%s",
-	"formatted_body": "%s"
-}`,
-		buildPlaintextMessageBody(endpoint, alert, result, resolved),
-		buildHTMLMessageBody(endpoint, alert, result, resolved),
-	)
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return fallback
+	}
+	var wellKnown struct {
+		Homeserver struct {
+			BaseURL string `json:"base_url"`
+		} `json:"m.homeserver"`
+	}
+	if err := json.NewDecoder(response.Body).Decode(&wellKnown); err != nil || wellKnown.Homeserver.BaseURL == "" {
+		return fallback
+	}
+	return strings.TrimSuffix(wellKnown.Homeserver.BaseURL, "/")
+}
+
+// messageEventContent is the content of an m.room.message event, marshaled via encoding/json
+// rather than string-formatted so that a condition string, description or display name containing
+// a quote, backslash or newline can't produce invalid JSON or inject additional keys into the
+// outgoing event.
+type messageEventContent struct {
+	MsgType       string         `json:"msgtype"`
+	Format        string         `json:"format"`
+	Body          string         `json:"body"`
+	FormattedBody string         `json:"formatted_body"`
+	RelatesTo     *eventRelation `json:"m.relates_to,omitempty"`
+}
+
+// eventRelation is an m.thread relation, used to thread related alerts under their root event
+type eventRelation struct {
+	RelType       string      `json:"rel_type"`
+	EventID       string      `json:"event_id"`
+	IsFallingBack bool        `json:"is_falling_back"`
+	InReplyTo     eventInReplyTo `json:"m.in_reply_to"`
+}
+
+// eventInReplyTo is the fallback m.in_reply_to relation nested under an m.thread relation
+type eventInReplyTo struct {
+	EventID string `json:"event_id"`
+}
+
+// buildRequestBody builds the request body for the provider. When threadRootEventID isn't empty,
+// the event is related to it as part of an m.thread so that Matrix clients group it under the
+// alert's original triggered message instead of showing it as a new top-level event.
+func (provider *AlertProvider) buildRequestBody(endpoint *core.Endpoint, alert *alert.Alert, result *core.Result, resolved bool, threadRootEventID string) (string, error) {
+	content := messageEventContent{
+		MsgType:       "m.text",
+		Format:        "org.matrix.custom.html",
+		Body:          buildPlaintextMessageBody(endpoint, alert, result, resolved),
+		FormattedBody: buildHTMLMessageBody(endpoint, alert, result, resolved),
+	}
+	if threadRootEventID != "" {
+		content.RelatesTo = &eventRelation{
+			RelType:       "m.thread",
+			EventID:       threadRootEventID,
+			IsFallingBack: true,
+			InReplyTo:     eventInReplyTo{EventID: threadRootEventID},
+		}
+	}
+	encoded, err := json.Marshal(content)
+	if err != nil {
+		return "", err
+	}
+	return string(encoded), nil
 }
 
 // buildPlaintextMessageBody builds the message body in plaintext to include in request
@@ -122,13 +283,13 @@ func buildPlaintextMessageBody(endpoint *core.Endpoint, alert *alert.Alert, resu
 		} else {
 			prefix = "✕"
 		}
-		results += fmt.Sprintf("\\n%s - %s", prefix, conditionResult.Condition)
+		results += fmt.Sprintf("\n%s - %s", prefix, conditionResult.Condition)
 	}
 	var description string
 	if alertDescription := alert.GetDescription(); len(alertDescription) > 0 {
-		description = "\\n" + alertDescription
+		description = "\n" + alertDescription
 	}
-	return fmt.Sprintf("%s%s\\n%s", message, description, results)
+	return fmt.Sprintf("%s%s\n%s", message, description, results)
 }
 
 // buildHTMLMessageBody builds the message body in HTML to include in request
@@ -150,9 +311,9 @@ func buildHTMLMessageBody(endpoint *core.Endpoint, alert *alert.Alert, result *c
 	}
 	var description string
 	if alertDescription := alert.GetDescription(); len(alertDescription) > 0 {
-		description = fmt.Sprintf("\\n<blockquote>%s</blockquote>", alertDescription)
+		description = fmt.Sprintf("\n<blockquote>%s</blockquote>", alertDescription)
 	}
-	return fmt.Sprintf("<h3>%s</h3>%s\\n<h5>Condition results</h5><ul>%s</ul>", message, description, results)
+	return fmt.Sprintf("<h3>%s</h3>%s\n<h5>Condition results</h5><ul>%s</ul>", message, description, results)
 }
 
 // getConfigForGroup returns the appropriate configuration for a given group
@@ -175,15 +336,32 @@ func (provider *AlertProvider) getConfigForGroup(group string) matrixProviderCon
 	}
 }
 
-const letterBytes = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+// txnNonce is generated once per process (not reseeded per call, unlike the previous
+// rand.Seed(time.Now().UnixNano()) implementation) so that transaction IDs from two Gatus
+// processes never collide
+var txnNonce = generateTxnNonce()
 
-func randStringBytes(n int) string {
-	b := make([]byte, n)
-	rand.Seed(time.Now().UnixNano())
-	for i := range b {
-		b[i] = letterBytes[rand.Intn(len(letterBytes))]
+// txnCounter is a monotonic, process-wide counter folded into every transaction ID alongside
+// txnNonce. Advancing it via sync/atomic instead of reseeding math/rand means two alerts sent in
+// the same nanosecond still get distinct counter values, so they can no longer collide.
+var txnCounter uint64
+
+func generateTxnNonce() string {
+	b := make([]byte, 8)
+	if _, err := cryptorand.Read(b); err != nil {
+		// crypto/rand.Read on the system CSPRNG isn't expected to fail; if it somehow does, fall
+		// back to a fixed nonce rather than panicking. Uniqueness is still guaranteed by txnCounter.
+		return "gatus"
 	}
-	return string(b)
+	return hex.EncodeToString(b)
+}
+
+// nextTxnID returns the next transaction ID to use for a PUT to the Matrix send endpoint. It's
+// computed once per logical send and then reused across that send's own retries (e.g. SendRaw's
+// v3-to-r0 fallback), so that Matrix's idempotency guarantees correctly treat those as
+// retransmissions of the same event rather than distinct messages.
+func nextTxnID() string {
+	return fmt.Sprintf("%s-%d", txnNonce, atomic.AddUint64(&txnCounter, 1))
 }
 
 // GetDefaultAlert returns the provider's default alert configuration
@@ -0,0 +1,67 @@
+package matrix
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// TestNextTxnIDIsUniqueUnderConcurrency ensures that calling nextTxnID from many goroutines at
+// once never hands out the same transaction ID twice, which would make Matrix treat two distinct
+// messages as retransmissions of one another and drop one of them.
+func TestNextTxnIDIsUniqueUnderConcurrency(t *testing.T) {
+	const calls = 1000
+	ids := make([]string, calls)
+	var wg sync.WaitGroup
+	wg.Add(calls)
+	for i := 0; i < calls; i++ {
+		go func(i int) {
+			defer wg.Done()
+			ids[i] = nextTxnID()
+		}(i)
+	}
+	wg.Wait()
+	seen := make(map[string]bool, calls)
+	for _, id := range ids {
+		if seen[id] {
+			t.Fatalf("nextTxnID returned the same transaction ID twice: %s", id)
+		}
+		seen[id] = true
+	}
+}
+
+// TestSendRawFallsBackFromV3ToR0 asserts that SendRaw retries against the deprecated r0 endpoint,
+// reusing the same transaction ID, when the homeserver has no v3 send endpoint
+func TestSendRawFallsBackFromV3ToR0(t *testing.T) {
+	var v3TxnID, r0TxnID string
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		switch {
+		case strings.Contains(request.URL.Path, "/_matrix/client/v3/rooms/"):
+			v3TxnID = request.URL.Path[strings.LastIndex(request.URL.Path, "/")+1:]
+			writer.WriteHeader(http.StatusNotFound)
+		case strings.Contains(request.URL.Path, "/_matrix/client/r0/rooms/"):
+			r0TxnID = request.URL.Path[strings.LastIndex(request.URL.Path, "/")+1:]
+			writer.WriteHeader(http.StatusOK)
+			writer.Write([]byte(`{"event_id":"$abc123"}`))
+		default:
+			t.Fatalf("unexpected request path: %s", request.URL.Path)
+		}
+	}))
+	defer server.Close()
+	provider := &AlertProvider{AccessToken: "token", InternalRoomID: "!room:example.com"}
+	eventID, err := provider.SendRaw(server.URL, provider.AccessToken, provider.InternalRoomID, `{"msgtype":"m.text","body":"hi"}`)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if eventID != "$abc123" {
+		t.Errorf("expected event_id $abc123, got %s", eventID)
+	}
+	if v3TxnID == "" || r0TxnID == "" {
+		t.Fatalf("expected both the v3 and r0 endpoints to be hit, got v3=%q r0=%q", v3TxnID, r0TxnID)
+	}
+	if v3TxnID != r0TxnID {
+		t.Errorf("expected the r0 fallback to reuse the v3 attempt's transaction ID, got v3=%s r0=%s", v3TxnID, r0TxnID)
+	}
+}
@@ -0,0 +1,64 @@
+package matrix
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/TwiN/gatus/v4/alerting/alert"
+	"github.com/TwiN/gatus/v4/core"
+)
+
+// threadRootTTL bounds how long a triggered alert's event_id is remembered while waiting for a
+// matching resolved alert. Past this, a new failure is posted as a fresh top-level message rather
+// than threaded under a root that's likely no longer relevant.
+const threadRootTTL = 24 * time.Hour
+
+// threadRoot is the event_id of the first "triggered" message sent for a given endpoint/alert,
+// along with when that association should be forgotten
+type threadRoot struct {
+	eventID   string
+	expiresAt time.Time
+}
+
+// threadKey identifies the conversation a given endpoint/alert pair's messages should be threaded
+// under
+func (provider *AlertProvider) threadKey(endpoint *core.Endpoint, alert *alert.Alert) string {
+	return fmt.Sprintf("%s:%s", endpoint.DisplayName(), alert.Type)
+}
+
+// getThreadRoot returns the event_id that key's messages should be threaded under, or an empty
+// string if there's no unexpired root for it
+func (provider *AlertProvider) getThreadRoot(key string) string {
+	provider.threadMutex.Lock()
+	defer provider.threadMutex.Unlock()
+	root, ok := provider.threadRoots[key]
+	if !ok {
+		return ""
+	}
+	if time.Now().After(root.expiresAt) {
+		delete(provider.threadRoots, key)
+		return ""
+	}
+	return root.eventID
+}
+
+// setThreadRoot records eventID as the root that key's subsequent messages should thread under
+func (provider *AlertProvider) setThreadRoot(key, eventID string) {
+	if eventID == "" {
+		return
+	}
+	provider.threadMutex.Lock()
+	defer provider.threadMutex.Unlock()
+	if provider.threadRoots == nil {
+		provider.threadRoots = make(map[string]threadRoot)
+	}
+	provider.threadRoots[key] = threadRoot{eventID: eventID, expiresAt: time.Now().Add(threadRootTTL)}
+}
+
+// clearThreadRoot forgets key's thread root, e.g. once its alert has been resolved
+func (provider *AlertProvider) clearThreadRoot(key string) {
+	provider.threadMutex.Lock()
+	defer provider.threadMutex.Unlock()
+	delete(provider.threadRoots, key)
+}
@@ -0,0 +1,55 @@
+package matrix
+
+import (
+	"testing"
+	"time"
+)
+
+// TestGetThreadRootExpires asserts that a thread root past its TTL is treated as absent and
+// evicted, rather than threading a new alert under a root that's likely no longer relevant
+func TestGetThreadRootExpires(t *testing.T) {
+	provider := &AlertProvider{}
+	provider.setThreadRoot("endpoint:failure", "$root")
+	if got := provider.getThreadRoot("endpoint:failure"); got != "$root" {
+		t.Fatalf("expected freshly-set thread root to be returned, got %q", got)
+	}
+	// Backdate the root past its TTL instead of waiting threadRootTTL out
+	provider.threadMutex.Lock()
+	provider.threadRoots["endpoint:failure"] = threadRoot{eventID: "$root", expiresAt: time.Now().Add(-time.Second)}
+	provider.threadMutex.Unlock()
+	if got := provider.getThreadRoot("endpoint:failure"); got != "" {
+		t.Errorf("expected an expired thread root to be ignored, got %q", got)
+	}
+	provider.threadMutex.Lock()
+	_, stillPresent := provider.threadRoots["endpoint:failure"]
+	provider.threadMutex.Unlock()
+	if stillPresent {
+		t.Error("expected the expired thread root to be evicted from the map")
+	}
+}
+
+// TestClearThreadRootForgetsKey asserts that clearing a thread root removes it even though it
+// hasn't expired yet, e.g. once the alert it was tracking has been resolved
+func TestClearThreadRootForgetsKey(t *testing.T) {
+	provider := &AlertProvider{}
+	provider.setThreadRoot("endpoint:failure", "$root")
+	provider.clearThreadRoot("endpoint:failure")
+	if got := provider.getThreadRoot("endpoint:failure"); got != "" {
+		t.Errorf("expected thread root to be cleared, got %q", got)
+	}
+}
+
+// TestSetThreadRootIgnoresEmptyEventID asserts that a failed send (whose eventID will be empty)
+// doesn't clobber or create a thread root
+func TestSetThreadRootIgnoresEmptyEventID(t *testing.T) {
+	provider := &AlertProvider{}
+	provider.setThreadRoot("endpoint:failure", "")
+	if got := provider.getThreadRoot("endpoint:failure"); got != "" {
+		t.Errorf("expected no thread root to be recorded, got %q", got)
+	}
+	provider.setThreadRoot("endpoint:failure", "$root")
+	provider.setThreadRoot("endpoint:failure", "")
+	if got := provider.getThreadRoot("endpoint:failure"); got != "$root" {
+		t.Errorf("expected existing thread root to be kept, got %q", got)
+	}
+}
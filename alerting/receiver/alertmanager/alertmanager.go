@@ -0,0 +1,228 @@
+package alertmanager
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html"
+	"net/http"
+	"text/template"
+
+	"github.com/TwiN/gatus/v4/alerting/provider/matrix"
+	alertmanagertemplate "github.com/prometheus/alertmanager/template"
+)
+
+// messageEventContent is the content of an m.room.message event, marshaled via encoding/json
+// rather than string-formatted so that alert labels/annotations containing quotes, backslashes
+// or newlines can't produce invalid JSON or inject additional keys into the outgoing event.
+type messageEventContent struct {
+	MsgType       string `json:"msgtype"`
+	Format        string `json:"format"`
+	Body          string `json:"body"`
+	FormattedBody string `json:"formatted_body"`
+}
+
+// severityPrefix maps an Alertmanager `severity` label to the emoji Gatus prefixes the rendered
+// message with. Alerts without a recognized severity label fall back to no prefix at all.
+var severityPrefix = map[string]string{
+	"warning":  "⚠️",
+	"critical": "🔥",
+	"info":     "ℹ️",
+}
+
+// Config is the configuration necessary to receive Alertmanager webhook payloads and forward
+// them to Matrix. It's intentionally separate from alerting.Config: Alertmanager pushes alerts
+// to Gatus rather than Gatus polling endpoints, so there's no EndpointAlert/Alert pairing to reuse.
+type Config struct {
+	// Matrix is the provider used to deliver the rendered alert, reused as-is for its
+	// homeserver/access-token/default-room configuration.
+	Matrix *matrix.AlertProvider `yaml:"matrix"`
+
+	// Routes overrides the destination room based on the incoming alert's labels.
+	// The first Route whose Labels all match the alert's labels wins; if none match,
+	// the Matrix provider's InternalRoomID is used.
+	Routes []Route `yaml:"routes,omitempty"`
+}
+
+// Route is a label-based override of the Matrix destination for a matching Alertmanager alert
+type Route struct {
+	// Labels that must all be present and equal on the alert for this Route to apply
+	Labels map[string]string `yaml:"labels"`
+
+	// HomeserverURL overrides Config.Matrix.HomeserverURL for alerts matched by this Route (optional)
+	HomeserverURL string `yaml:"homeserver-url,omitempty"`
+	// AccessToken overrides Config.Matrix.AccessToken for alerts matched by this Route (optional)
+	AccessToken string `yaml:"access-token,omitempty"`
+	// RoomID is the room to deliver matched alerts to
+	RoomID string `yaml:"room-id"`
+}
+
+// IsValid returns whether the configuration is valid
+func (cfg *Config) IsValid() bool {
+	if cfg.Matrix == nil || !cfg.Matrix.IsValid() {
+		return false
+	}
+	for _, route := range cfg.Routes {
+		if len(route.Labels) == 0 || len(route.RoomID) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Handler handles Alertmanager v4 webhook payloads (prometheus/alertmanager/template.Data) and
+// forwards each alert to the room resolved for its labels.
+//
+// It's meant to be registered on the main HTTP router, e.g.:
+//
+//	router.HandleFunc("/api/v1/alertmanager/webhook", config.Handler)
+func (cfg *Config) Handler(writer http.ResponseWriter, request *http.Request) {
+	var payload alertmanagertemplate.Data
+	if err := json.NewDecoder(request.Body).Decode(&payload); err != nil {
+		writer.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	// Keep sending the rest of the batch even if one alert fails, so that one failure doesn't stop
+	// the alerts after it from being delivered. Any failure still answers with a non-2xx status,
+	// on which Alertmanager retries the whole webhook, so alerts that already made it to Matrix are
+	// sent again (and duplicated in the room) on that retry.
+	var failed int
+	for _, alertmanagerAlert := range payload.Alerts {
+		homeserverURL, accessToken, roomID := cfg.resolveRoom(alertmanagerAlert.Labels)
+		body, err := buildRequestBody(alertmanagerAlert)
+		if err != nil {
+			failed++
+			continue
+		}
+		if _, err := cfg.Matrix.SendRaw(homeserverURL, accessToken, roomID, body); err != nil {
+			failed++
+			continue
+		}
+	}
+	if failed > 0 {
+		writer.WriteHeader(http.StatusBadGateway)
+		return
+	}
+	writer.WriteHeader(http.StatusOK)
+}
+
+// resolveRoom returns the homeserver URL, access token and room ID that an alert with the given
+// labels should be delivered to, applying the first matching Route if any
+func (cfg *Config) resolveRoom(labels alertmanagertemplate.KV) (homeserverURL, accessToken, roomID string) {
+	for _, route := range cfg.Routes {
+		if routeMatches(route.Labels, labels) {
+			homeserverURL, accessToken = route.HomeserverURL, route.AccessToken
+			if homeserverURL == "" {
+				homeserverURL = cfg.Matrix.HomeserverURL
+			}
+			if accessToken == "" {
+				accessToken = cfg.Matrix.AccessToken
+			}
+			return homeserverURL, accessToken, route.RoomID
+		}
+	}
+	return cfg.Matrix.HomeserverURL, cfg.Matrix.AccessToken, cfg.Matrix.InternalRoomID
+}
+
+// routeMatches returns whether every label in want is present and equal in have
+func routeMatches(want map[string]string, have alertmanagertemplate.KV) bool {
+	for key, value := range want {
+		if have[key] != value {
+			return false
+		}
+	}
+	return true
+}
+
+// buildRequestBody builds the Matrix m.room.message event body for an Alertmanager alert
+func buildRequestBody(alertmanagerAlert alertmanagertemplate.Alert) (string, error) {
+	content := messageEventContent{
+		MsgType:       "m.text",
+		Format:        "org.matrix.custom.html",
+		Body:          buildPlaintextMessageBody(alertmanagerAlert),
+		FormattedBody: buildHTMLMessageBody(alertmanagerAlert),
+	}
+	encoded, err := json.Marshal(content)
+	if err != nil {
+		return "", err
+	}
+	return string(encoded), nil
+}
+
+// roomMention returns the "@room " prefix that must be present in both the plaintext body (where
+// Matrix's default m.rule.roomnotif push rule looks for it) and the formatted body, for a firing
+// alert with severity=critical
+func roomMention(alertmanagerAlert alertmanagertemplate.Alert) string {
+	if alertmanagerAlert.Status != "resolved" && alertmanagerAlert.Labels["severity"] == "critical" {
+		return "@room "
+	}
+	return ""
+}
+
+// buildPlaintextMessageBody builds the plaintext rendering of an Alertmanager alert
+func buildPlaintextMessageBody(alertmanagerAlert alertmanagertemplate.Alert) string {
+	prefix := severityPrefix[alertmanagerAlert.Labels["severity"]]
+	mention := roomMention(alertmanagerAlert)
+	var message string
+	if alertmanagerAlert.Status == "resolved" {
+		message = fmt.Sprintf("%s Alert resolved: %s", prefix, alertmanagerAlert.Labels["alertname"])
+	} else {
+		message = fmt.Sprintf("%s%s Alert firing: %s", mention, prefix, alertmanagerAlert.Labels["alertname"])
+	}
+	summary := renderAnnotation(alertmanagerAlert.Annotations["summary"], alertmanagerAlert)
+	description := renderAnnotation(alertmanagerAlert.Annotations["description"], alertmanagerAlert)
+	body := message
+	if summary != "" {
+		body += "\n" + summary
+	}
+	if description != "" {
+		body += "\n" + description
+	}
+	return body
+}
+
+// buildHTMLMessageBody builds the HTML rendering of an Alertmanager alert, linking GeneratorURL
+// and, when present, the alert's service_url annotation. Every value interpolated here can come
+// from an attacker-influenced Alertmanager label/annotation, so each is HTML-escaped first.
+func buildHTMLMessageBody(alertmanagerAlert alertmanagertemplate.Alert) string {
+	prefix := severityPrefix[alertmanagerAlert.Labels["severity"]]
+	mention := roomMention(alertmanagerAlert)
+	alertname := html.EscapeString(alertmanagerAlert.Labels["alertname"])
+	var message string
+	if alertmanagerAlert.Status == "resolved" {
+		message = fmt.Sprintf("%s Alert resolved: <code>%s</code>", prefix, alertname)
+	} else {
+		message = fmt.Sprintf("%s%s Alert firing: <code>%s</code>", mention, prefix, alertname)
+	}
+	summary := html.EscapeString(renderAnnotation(alertmanagerAlert.Annotations["summary"], alertmanagerAlert))
+	description := html.EscapeString(renderAnnotation(alertmanagerAlert.Annotations["description"], alertmanagerAlert))
+	body := fmt.Sprintf("<h3>%s</h3>", message)
+	if summary != "" {
+		body += fmt.Sprintf("<p>%s</p>", summary)
+	}
+	if description != "" {
+		body += fmt.Sprintf("<blockquote>%s</blockquote>", description)
+	}
+	body += fmt.Sprintf(`<p><a href="%s">Generator</a></p>`, html.EscapeString(alertmanagerAlert.GeneratorURL))
+	if serviceURL := alertmanagerAlert.Annotations["service_url"]; serviceURL != "" {
+		body += fmt.Sprintf(`<p><a href="%s">Service</a></p>`, html.EscapeString(serviceURL))
+	}
+	return body
+}
+
+// renderAnnotation executes annotation as a text/template against alertmanagerAlert, returning
+// the raw annotation unmodified if it isn't a valid template
+func renderAnnotation(annotation string, alertmanagerAlert alertmanagertemplate.Alert) string {
+	if annotation == "" {
+		return ""
+	}
+	tmpl, err := template.New("annotation").Parse(annotation)
+	if err != nil {
+		return annotation
+	}
+	var rendered bytes.Buffer
+	if err := tmpl.Execute(&rendered, alertmanagerAlert); err != nil {
+		return annotation
+	}
+	return rendered.String()
+}